@@ -0,0 +1,169 @@
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// CodecType is the wire tag identifying which codec a RPC request/response
+// body was encoded with. It travels in the fixed request header every
+// CmdRpcMethod call carries ahead of its codec-marshaled payload, see
+// encodeRpcHeader/decodeRpcHeader
+type CodecType byte
+
+const (
+	CodecJSON     CodecType = 1
+	CodecProtobuf CodecType = 2
+	CodecMsgpack  CodecType = 3
+	CodecGob      CodecType = 4
+)
+
+// ErrCodecNotRegistered is returned by CallWithCodec when no codec is
+// registered for a given CodecType tag. The server-side dispatcher installed
+// by ServeRpc does not reply with it: it logs and drops such a request
+var ErrCodecNotRegistered = errors.New("rpc: no codec registered for tag")
+
+// rpcHeaderSize is the size, in bytes, of the fixed header every
+// CmdRpcMethod request (Call/CallWithCodec/CallContext) carries ahead of
+// its codec-marshaled payload: a 1-byte codec tag followed by an 8-byte
+// deadline. Both fields are always present, tag 0 / deadline 0 meaning
+// "unset", so the two features never have to fight over the same prefix
+// byte the way independent ad hoc prefixes would
+const rpcHeaderSize = 9
+
+// encodeRpcHeader builds the fixed header for a CmdRpcMethod request; tag 0
+// tells the server dispatcher to use its default codec, a zero deadline
+// means the call carries none
+func encodeRpcHeader(tag CodecType, deadline time.Time) []byte {
+	header := make([]byte, rpcHeaderSize)
+	header[0] = byte(tag)
+	if !deadline.IsZero() {
+		binary.BigEndian.PutUint64(header[1:], uint64(deadline.UnixNano()))
+	}
+	return header
+}
+
+// decodeRpcHeader splits a CmdRpcMethod request's fixed header from its
+// payload. The server dispatcher calls this before selecting a codec or
+// registering the handler's deadline; bodies shorter than the header (e.g.
+// CmdRpcCancel's nil body) are returned unchanged
+func decodeRpcHeader(body []byte) (tag CodecType, deadline time.Time, payload []byte) {
+	if len(body) < rpcHeaderSize {
+		return 0, time.Time{}, body
+	}
+	tag = CodecType(body[0])
+	if nanos := binary.BigEndian.Uint64(body[1:rpcHeaderSize]); nanos != 0 {
+		deadline = time.Unix(0, int64(nanos))
+	}
+	return tag, deadline, body[rpcHeaderSize:]
+}
+
+// seqCodec tracks, per connection, the codec a CmdRpcMethod request's
+// header selected for the handler currently being run for a given RpcSeq.
+// RpcSeq is only unique within a single *TcpClient, so this is keyed by
+// connection rather than by engine, the same reasoning as cancelRegistry.
+// The server dispatcher installed by ServeRpc populates it before calling
+// the handler and drains it afterwards
+var seqCodec = struct {
+	sync.Mutex
+	byClient map[*TcpClient]map[int64]ICodec
+}{byClient: map[*TcpClient]map[int64]ICodec{}}
+
+// registerHandlerCodec records codec as the codec selected for seq's
+// in-flight handler on c; the returned func removes it and must be
+// deferred by the caller
+func registerHandlerCodec(c *TcpClient, seq int64, codec ICodec) func() {
+	seqCodec.Lock()
+	seqs, ok := seqCodec.byClient[c]
+	if !ok {
+		seqs = map[int64]ICodec{}
+		seqCodec.byClient[c] = seqs
+		c.OnClose("rpc-codec", func(*TcpClient) {
+			seqCodec.Lock()
+			delete(seqCodec.byClient, c)
+			seqCodec.Unlock()
+		})
+	}
+	seqs[seq] = codec
+	seqCodec.Unlock()
+
+	return func() {
+		seqCodec.Lock()
+		delete(seqCodec.byClient[c], seq)
+		seqCodec.Unlock()
+	}
+}
+
+// HandlerCodec returns the codec CallWithCodec's tag header selected for an
+// in-flight handler's RpcSeq on c; ok is false when the request carried no
+// tag (tag 0), meaning the handler should fall back to its own default
+// codec. Pairs with decodeRpcHeader(msg.Body()) for reading the actual
+// payload
+func HandlerCodec(c *TcpClient, seq int64) (codec ICodec, ok bool) {
+	seqCodec.Lock()
+	codec, ok = seqCodec.byClient[c][seq]
+	seqCodec.Unlock()
+	return codec, ok
+}
+
+// CodecRegistry maps a wire CodecType tag to the ICodec that (un)marshals
+// it, letting a single connection mix codecs per call -- e.g. a
+// protobuf-heavy hot path alongside a JSON debug endpoint
+type CodecRegistry struct {
+	sync.RWMutex
+	codecs map[CodecType]ICodec
+}
+
+// codec registry factory
+func NewCodecRegistry() *CodecRegistry {
+	return &CodecRegistry{codecs: map[CodecType]ICodec{}}
+}
+
+// register associates tag with codec, overwriting any previous registration
+func (r *CodecRegistry) Register(tag CodecType, codec ICodec) {
+	r.Lock()
+	r.codecs[tag] = codec
+	r.Unlock()
+}
+
+// get returns the codec registered for tag
+func (r *CodecRegistry) Get(tag CodecType) (ICodec, bool) {
+	r.RLock()
+	codec, ok := r.codecs[tag]
+	r.RUnlock()
+	return codec, ok
+}
+
+// DefaultCodecRegistry is pre-seeded with the out-of-box JSON (the existing
+// DefaultCodec) and Protobuf codecs; register Msgpack/Gob or custom codecs
+// into it, or into a private CodecRegistry, as needed
+var DefaultCodecRegistry = NewCodecRegistry()
+
+func init() {
+	DefaultCodecRegistry.Register(CodecJSON, DefaultCodec)
+	DefaultCodecRegistry.Register(CodecProtobuf, ProtobufCodec{})
+}
+
+// ProtobufCodec marshals/unmarshals using the protobuf wire format; req/rsp
+// passed to it must implement proto.Message
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.New("protobuf codec: value does not implement proto.Message")
+	}
+	return proto.Marshal(msg)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return errors.New("protobuf codec: value does not implement proto.Message")
+	}
+	return proto.Unmarshal(data, msg)
+}
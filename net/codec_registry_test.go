@@ -0,0 +1,52 @@
+package net
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEncodeDecodeRpcHeaderRoundTrip(t *testing.T) {
+	cases := []struct {
+		name     string
+		tag      CodecType
+		deadline time.Time
+		payload  []byte
+	}{
+		{"no tag, no deadline", 0, time.Time{}, []byte("hello")},
+		{"tag, no deadline", CodecProtobuf, time.Time{}, []byte{1, 2, 3}},
+		{"no tag, deadline", 0, time.Unix(1700000000, 123000), nil},
+		{"tag and deadline", CodecMsgpack, time.Unix(1700000000, 456000), []byte("payload")},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			header := encodeRpcHeader(c.tag, c.deadline)
+			if len(header) != rpcHeaderSize {
+				t.Fatalf("encodeRpcHeader produced %d bytes, want %d", len(header), rpcHeaderSize)
+			}
+
+			body := append(header, c.payload...)
+			tag, deadline, payload := decodeRpcHeader(body)
+			if tag != c.tag {
+				t.Errorf("tag = %v, want %v", tag, c.tag)
+			}
+			if !deadline.Equal(c.deadline) {
+				t.Errorf("deadline = %v, want %v", deadline, c.deadline)
+			}
+			if string(payload) != string(c.payload) {
+				t.Errorf("payload = %v, want %v", payload, c.payload)
+			}
+		})
+	}
+}
+
+func TestDecodeRpcHeaderShortBody(t *testing.T) {
+	body := []byte{1, 2, 3}
+	tag, deadline, payload := decodeRpcHeader(body)
+	if tag != 0 || !deadline.IsZero() {
+		t.Errorf("decodeRpcHeader on a too-short body should report unset tag/deadline, got tag=%v deadline=%v", tag, deadline)
+	}
+	if string(payload) != string(body) {
+		t.Errorf("decodeRpcHeader on a too-short body should return it unchanged, got %v", payload)
+	}
+}
@@ -0,0 +1,42 @@
+package net
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrRegistryClosed is returned once a Registry has been closed and can no
+// longer serve Resolve/Watch requests
+var ErrRegistryClosed = errors.New("registry closed")
+
+// ServiceAttr describes one service instance as published to a Registry
+type ServiceAttr struct {
+	Name     string
+	Addr     string
+	Metadata map[string]string
+}
+
+// WatchEvent describes a single service endpoint add/remove event delivered
+// by Registry.Watch
+type WatchEvent struct {
+	Add     bool
+	Service ServiceAttr
+}
+
+// Registry is the service discovery abstraction used by NewRpcClientFromRegistry
+// and TcpServer.RegisterService to publish and resolve service endpoints.
+// Provided implementations are EtcdRegistry and ZookeeperRegistry
+type Registry interface {
+	// register publishes attr under a lease that expires after ttl unless
+	// refreshed, and keeps refreshing it until Deregister is called
+	Register(attr ServiceAttr, ttl time.Duration) error
+
+	// deregister removes a previously registered service instance
+	Deregister(attr ServiceAttr) error
+
+	// resolve returns the currently known live endpoints for serviceName
+	Resolve(serviceName string) ([]ServiceAttr, error)
+
+	// watch streams add/remove events for serviceName until stopped is closed
+	Watch(serviceName string, stopped <-chan struct{}) (<-chan WatchEvent, error)
+}
@@ -0,0 +1,156 @@
+package net
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	clientv3 "github.com/coreos/etcd/clientv3"
+	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/util"
+)
+
+// default key prefix services are published under, <prefix><name>/<addr>
+const defaultEtcdRegistryPrefix = "/kiss/services/"
+
+// EtcdRegistry implements Registry on top of etcd v3, publishing each
+// service instance as a lease-bound key and watching the service's key
+// prefix for add/remove events
+type EtcdRegistry struct {
+	sync.Mutex
+	client *clientv3.Client
+	prefix string
+	leases map[string]clientv3.LeaseID
+}
+
+// etcd registry factory
+func NewEtcdRegistry(endpoints []string, dialTimeout time.Duration) (*EtcdRegistry, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: dialTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EtcdRegistry{
+		client: client,
+		prefix: defaultEtcdRegistryPrefix,
+		leases: map[string]clientv3.LeaseID{},
+	}, nil
+}
+
+func (r *EtcdRegistry) key(attr ServiceAttr) string {
+	return r.prefix + attr.Name + "/" + attr.Addr
+}
+
+// register publishes attr with a TTL lease and keeps the lease alive in the
+// background until Deregister is called
+func (r *EtcdRegistry) Register(attr ServiceAttr, ttl time.Duration) error {
+	lease, err := r.client.Grant(context.Background(), int64(ttl/time.Second))
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(attr)
+	if err != nil {
+		return err
+	}
+
+	if _, err = r.client.Put(context.Background(), r.key(attr), string(data), clientv3.WithLease(lease.ID)); err != nil {
+		return err
+	}
+
+	keepalive, err := r.client.KeepAlive(context.Background(), lease.ID)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	r.leases[r.key(attr)] = lease.ID
+	r.Unlock()
+
+	util.Go(func() {
+		for range keepalive {
+		}
+		log.Debug("etcd registry: lease for %v expired", attr.Addr)
+	})
+
+	return nil
+}
+
+// deregister revokes the lease and removes the published key
+func (r *EtcdRegistry) Deregister(attr ServiceAttr) error {
+	key := r.key(attr)
+
+	r.Lock()
+	lease, ok := r.leases[key]
+	delete(r.leases, key)
+	r.Unlock()
+
+	if ok {
+		r.client.Revoke(context.Background(), lease)
+	}
+
+	_, err := r.client.Delete(context.Background(), key)
+	return err
+}
+
+// resolve lists every key currently published under the service prefix
+func (r *EtcdRegistry) Resolve(serviceName string) ([]ServiceAttr, error) {
+	resp, err := r.client.Get(context.Background(), r.prefix+serviceName+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make([]ServiceAttr, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var attr ServiceAttr
+		if err := json.Unmarshal(kv.Value, &attr); err == nil {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	return attrs, nil
+}
+
+// watch streams put/delete events under the service prefix until stopped is closed
+func (r *EtcdRegistry) Watch(serviceName string, stopped <-chan struct{}) (<-chan WatchEvent, error) {
+	ch := make(chan WatchEvent, 16)
+	dir := r.prefix + serviceName + "/"
+	watchCh := r.client.Watch(context.Background(), dir, clientv3.WithPrefix())
+
+	util.Go(func() {
+		defer close(ch)
+		for {
+			select {
+			case <-stopped:
+				return
+			case resp, ok := <-watchCh:
+				if !ok {
+					return
+				}
+				for _, ev := range resp.Events {
+					if ev.Type == clientv3.EventTypeDelete {
+						// a DELETE carries no value, so the removed
+						// instance's addr is recovered from the key instead
+						ch <- WatchEvent{Add: false, Service: ServiceAttr{
+							Name: serviceName,
+							Addr: strings.TrimPrefix(string(ev.Kv.Key), dir),
+						}}
+						continue
+					}
+					var attr ServiceAttr
+					if err := json.Unmarshal(ev.Kv.Value, &attr); err != nil {
+						continue
+					}
+					ch <- WatchEvent{Add: true, Service: attr}
+				}
+			}
+		}
+	})
+
+	return ch, nil
+}
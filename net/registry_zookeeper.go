@@ -0,0 +1,210 @@
+package net
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/util"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// default znode path services are published under, <prefix>/<name>/<addr>
+const defaultZkRegistryPrefix = "/kiss/services"
+
+// ZookeeperRegistry implements Registry on top of ZooKeeper, publishing
+// each service instance as an ephemeral znode. ZooKeeper has no native TTL
+// lease, so the ttl passed to Register is only used as the recreate
+// interval: the znode is recreated on that cadence so it survives a
+// reconnect within ttl, and disappears on its own once the session expires
+type ZookeeperRegistry struct {
+	sync.Mutex
+	conn    *zk.Conn
+	prefix  string
+	stopped map[string]chan struct{}
+}
+
+// zookeeper registry factory
+func NewZookeeperRegistry(servers []string, sessionTimeout time.Duration) (*ZookeeperRegistry, error) {
+	conn, _, err := zk.Connect(servers, sessionTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ZookeeperRegistry{
+		conn:    conn,
+		prefix:  defaultZkRegistryPrefix,
+		stopped: map[string]chan struct{}{},
+	}, nil
+}
+
+func (r *ZookeeperRegistry) serviceDir(name string) string {
+	return r.prefix + "/" + name
+}
+
+func (r *ZookeeperRegistry) path(attr ServiceAttr) string {
+	return r.serviceDir(attr.Name) + "/" + strings.Replace(attr.Addr, "/", "_", -1)
+}
+
+func (r *ZookeeperRegistry) ensureDir(dir string) error {
+	parts := strings.Split(strings.TrimPrefix(dir, "/"), "/")
+	cur := ""
+	for _, part := range parts {
+		cur += "/" + part
+		if exists, _, err := r.conn.Exists(cur); err != nil {
+			return err
+		} else if !exists {
+			if _, err := r.conn.Create(cur, nil, 0, zk.WorldACL(zk.PermAll)); err != nil && err != zk.ErrNodeExists {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// register publishes attr as an ephemeral znode and recreates it every ttl
+// until Deregister is called
+func (r *ZookeeperRegistry) Register(attr ServiceAttr, ttl time.Duration) error {
+	if err := r.ensureDir(r.serviceDir(attr.Name)); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(attr)
+	if err != nil {
+		return err
+	}
+
+	path := r.path(attr)
+	if _, err := r.conn.Create(path, data, zk.FlagEphemeral, zk.WorldACL(zk.PermAll)); err != nil && err != zk.ErrNodeExists {
+		return err
+	}
+
+	stop := make(chan struct{})
+	r.Lock()
+	r.stopped[path] = stop
+	r.Unlock()
+
+	util.Go(func() {
+		ticker := time.NewTicker(ttl)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if exists, _, err := r.conn.Exists(path); err == nil && !exists {
+					if _, err := r.conn.Create(path, data, zk.FlagEphemeral, zk.WorldACL(zk.PermAll)); err != nil {
+						log.Debug("zookeeper registry: recreate %v failed: %v", path, err)
+					}
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+// deregister stops the refresh loop and removes the znode
+func (r *ZookeeperRegistry) Deregister(attr ServiceAttr) error {
+	path := r.path(attr)
+
+	r.Lock()
+	if stop, ok := r.stopped[path]; ok {
+		close(stop)
+		delete(r.stopped, path)
+	}
+	r.Unlock()
+
+	err := r.conn.Delete(path, -1)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	return err
+}
+
+// resolve lists every znode currently published under the service dir
+func (r *ZookeeperRegistry) Resolve(serviceName string) ([]ServiceAttr, error) {
+	dir := r.serviceDir(serviceName)
+	children, _, err := r.conn.Children(dir)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	attrs := make([]ServiceAttr, 0, len(children))
+	for _, child := range children {
+		data, _, err := r.conn.Get(dir + "/" + child)
+		if err != nil {
+			continue
+		}
+		var attr ServiceAttr
+		if err := json.Unmarshal(data, &attr); err == nil {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	return attrs, nil
+}
+
+// watch polls the service dir's child watch and diffs the child list to
+// emit add/remove events until stopped is closed. The first poll diffs
+// against an empty known set, so it emits an Add for every endpoint already
+// published -- callers that also do their own initial Resolve (e.g.
+// NewRpcClientFromRegistry) will see those addresses twice and must
+// de-duplicate against their existing connections before dialing
+func (r *ZookeeperRegistry) Watch(serviceName string, stopped <-chan struct{}) (<-chan WatchEvent, error) {
+	dir := r.serviceDir(serviceName)
+	if err := r.ensureDir(dir); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan WatchEvent, 16)
+
+	util.Go(func() {
+		defer close(ch)
+		known := map[string]ServiceAttr{}
+		for {
+			children, _, events, err := r.conn.ChildrenW(dir)
+			if err != nil {
+				log.Debug("zookeeper registry: watch %v failed: %v", dir, err)
+				return
+			}
+
+			current := map[string]ServiceAttr{}
+			for _, child := range children {
+				data, _, err := r.conn.Get(dir + "/" + child)
+				if err != nil {
+					continue
+				}
+				var attr ServiceAttr
+				if err := json.Unmarshal(data, &attr); err == nil {
+					current[child] = attr
+				}
+			}
+
+			for child, attr := range current {
+				if _, ok := known[child]; !ok {
+					ch <- WatchEvent{Add: true, Service: attr}
+				}
+			}
+			for child, attr := range known {
+				if _, ok := current[child]; !ok {
+					ch <- WatchEvent{Add: false, Service: attr}
+				}
+			}
+			known = current
+
+			select {
+			case <-stopped:
+				return
+			case <-events:
+			}
+		}
+	})
+
+	return ch, nil
+}
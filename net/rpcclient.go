@@ -102,7 +102,11 @@ func (client *RpcClient) Codec() ICodec {
 	return client.codec
 }
 
-// call cmd
+// call cmd. Unlike Call/CallWithCodec, an arbitrary cmd dispatched through
+// CallCmd carries no fixed header -- the caller owns the whole body, and
+// the server dispatcher never runs decodeRpcHeader against it, so this
+// stays wire-compatible with a server that has no ServeRpc/codec/deadline
+// support at all
 func (client *RpcClient) CallCmd(cmd uint32, req interface{}, rsp interface{}) error {
 	data, err := client.codec.Marshal(req)
 	if err != nil {
@@ -118,7 +122,7 @@ func (client *RpcClient) CallCmd(cmd uint32, req interface{}, rsp interface{}) e
 	return err
 }
 
-// call cmd with timeout
+// call cmd with timeout, see CallCmd for why the request carries no header
 func (client *RpcClient) CallCmdWithTimeout(cmd uint32, req interface{}, rsp interface{}, timeout time.Duration) error {
 	data, err := client.codec.Marshal(req)
 	if err != nil {
@@ -140,10 +144,11 @@ func (client *RpcClient) Call(method string, req interface{}, rsp interface{}, t
 	if err != nil {
 		return err
 	}
-	data = append(data, make([]byte, len(method)+1)...)
-	copy(data[len(data)-len(method)-1:], method)
-	data[len(data)-1] = byte(len(method))
-	rspdata, err := client.callCmdWithTimeout(CmdRpcMethod, data, timeout)
+	body := append(encodeRpcHeader(0, time.Time{}), data...)
+	body = append(body, make([]byte, len(method)+1)...)
+	copy(body[len(body)-len(method)-1:], method)
+	body[len(body)-1] = byte(len(method))
+	rspdata, err := client.callCmdWithTimeout(CmdRpcMethod, body, timeout)
 	if err != nil {
 		return err
 	}
@@ -153,6 +158,39 @@ func (client *RpcClient) Call(method string, req interface{}, rsp interface{}, t
 	return err
 }
 
+// rpc call using an explicit per-call codec tag instead of the client's
+// default codec, so one connection can mix codecs -- e.g. protobuf for a
+// hot-path method and JSON for a debug endpoint. tag travels in the fixed
+// request header every CmdRpcMethod call carries, ahead of the method name
+// Call already appends to the tail, so the server dispatcher installed by
+// ServeRpc can pick the matching codec from DefaultCodecRegistry for both
+// the request and the response
+func (client *RpcClient) CallWithCodec(tag CodecType, method string, req interface{}, rsp interface{}, timeout time.Duration) error {
+	codec, ok := DefaultCodecRegistry.Get(tag)
+	if !ok {
+		return ErrCodecNotRegistered
+	}
+
+	data, err := codec.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	body := append(encodeRpcHeader(tag, time.Time{}), data...)
+	body = append(body, make([]byte, len(method)+1)...)
+	copy(body[len(body)-len(method)-1:], method)
+	body[len(body)-1] = byte(len(method))
+
+	rspdata, err := client.callCmdWithTimeout(CmdRpcMethod, body, timeout)
+	if err != nil {
+		return err
+	}
+	if rsp != nil {
+		err = codec.Unmarshal(rspdata, rsp)
+	}
+	return err
+}
+
 // rpc client factory
 func NewRpcClient(addr string, engine *TcpEngin, codec ICodec, onConnected func(*TcpClient)) (*RpcClient, error) {
 	if engine == nil {
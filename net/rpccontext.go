@@ -0,0 +1,206 @@
+package net
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CmdRpcCancel is sent by a client to ask the server to abort the in-flight
+// handler for a previously issued RpcSeq, once the calling context is
+// cancelled or its deadline passes
+const CmdRpcCancel uint32 = 0xfffe
+
+// cancelEntry pairs a handler's context with the func that cancels it
+type cancelEntry struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// cancelRegistry tracks, per connection, the in-flight handler context for
+// every RpcSeq currently being handled. RpcSeq is only unique within a
+// single *TcpClient (each connection has its own sendSeq counter), so the
+// registry is keyed by connection rather than by engine -- two clients
+// dialed into the same engine can otherwise reuse the same seq and cancel
+// or steal each other's handler context. The server dispatcher installed by
+// ServeRpc populates it before calling a handler and drains it afterwards,
+// and resolves an incoming CmdRpcCancel to a CancelFunc here
+var cancelRegistry = struct {
+	sync.Mutex
+	byClient map[*TcpClient]map[int64]cancelEntry
+}{byClient: map[*TcpClient]map[int64]cancelEntry{}}
+
+// registerHandlerContext creates the context a handler for seq should
+// observe -- deadline-bound if deadline is non-zero, cancel-only otherwise
+// -- and registers it so a later CmdRpcCancel or deadline expiry aborts it.
+// The returned func both cancels the context and removes it from the
+// registry; callers must defer it
+func registerHandlerContext(c *TcpClient, seq int64, deadline time.Time) (context.Context, func()) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if !deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(context.Background(), deadline)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	cancelRegistry.Lock()
+	seqs, ok := cancelRegistry.byClient[c]
+	if !ok {
+		seqs = map[int64]cancelEntry{}
+		cancelRegistry.byClient[c] = seqs
+		c.OnClose("rpc-cancel", func(*TcpClient) {
+			cancelRegistry.Lock()
+			delete(cancelRegistry.byClient, c)
+			cancelRegistry.Unlock()
+		})
+	}
+	seqs[seq] = cancelEntry{ctx, cancel}
+	cancelRegistry.Unlock()
+
+	return ctx, func() {
+		cancelRegistry.Lock()
+		delete(cancelRegistry.byClient[c], seq)
+		cancelRegistry.Unlock()
+		cancel()
+	}
+}
+
+// cancelHandlerContext cancels the in-flight handler context registered for
+// seq on c, if any; called when a CmdRpcCancel arrives
+func cancelHandlerContext(c *TcpClient, seq int64) {
+	cancelRegistry.Lock()
+	entry, ok := cancelRegistry.byClient[c][seq]
+	cancelRegistry.Unlock()
+	if ok {
+		entry.cancel()
+	}
+}
+
+// HandlerContext returns the context registered for an in-flight handler's
+// RpcSeq, letting a handler registered via engine.HandleOnMethod/handlers
+// honor the caller's cancellation and deadline without changing the
+// handler's (*TcpClient, IMessage) signature
+func HandlerContext(c *TcpClient, seq int64) (context.Context, bool) {
+	cancelRegistry.Lock()
+	entry, ok := cancelRegistry.byClient[c][seq]
+	cancelRegistry.Unlock()
+	return entry.ctx, ok
+}
+
+// DecodeDeadline reads back the deadline CallContext/CallCmdContext set in
+// the request header, returning the payload that follows it. A zero time
+// means the caller set no deadline. For CmdRpcMethod requests the server
+// dispatcher installed by ServeRpc already uses this deadline to bound the
+// handler's context (see HandlerContext); for any other cmd sent with
+// CallCmdContext it does not, so that handler calls this on the request
+// body to strip the header and learn the deadline. A handler that wants to
+// inherit the remaining budget for a downstream call it makes itself can
+// call this directly on the request body before decoding its own payload
+func DecodeDeadline(body []byte) (time.Time, []byte) {
+	_, deadline, payload := decodeRpcHeader(body)
+	return deadline, payload
+}
+
+// callCmdContext mirrors callCmdWithTimeout but waits on ctx.Done() instead
+// of a fixed timer; on cancellation it sends CmdRpcCancel for this call's
+// seq so the server can abort the in-flight handler, then drops the local
+// session the same way callCmdWithTimeout does on timeout
+func (client *RpcClient) callCmdContext(ctx context.Context, cmd uint32, data []byte) ([]byte, error) {
+	var session *rpcsession
+	client.Lock()
+	if client.running {
+		session = &rpcsession{
+			seq:  atomic.AddInt64(&client.sendSeq, 1),
+			done: make(chan *RpcMessage, 1),
+		}
+		msg := NewRpcMessage(cmd, session.seq, data)
+		select {
+		case client.chSend <- asyncMessage{msg.data, nil}:
+			client.sessionMap[session.seq] = session
+		case <-ctx.Done():
+			client.Unlock()
+			return nil, ctx.Err()
+		}
+	} else {
+		client.Unlock()
+		return nil, ErrRpcClientIsDisconnected
+	}
+	client.Unlock()
+	defer client.removeSession(session.seq)
+
+	select {
+	case msg, ok := <-session.done:
+		if !ok {
+			return nil, ErrRpcClientIsDisconnected
+		}
+		return msg.msg.Body(), msg.err
+	case <-ctx.Done():
+		client.sendCancel(session.seq)
+		return nil, ctx.Err()
+	}
+}
+
+// sendCancel best-effort notifies the server to abort the in-flight
+// handler for seq; failures are ignored since the local session is being
+// dropped regardless
+func (client *RpcClient) sendCancel(seq int64) {
+	client.Lock()
+	running := client.running
+	client.Unlock()
+	if !running {
+		return
+	}
+
+	msg := NewRpcMessage(CmdRpcCancel, seq, nil)
+	select {
+	case client.chSend <- asyncMessage{msg.data, nil}:
+	default:
+	}
+}
+
+// call cmd honoring ctx.Done() in place of a fixed timeout; the context's
+// deadline, if any, is also propagated to the server in the body so
+// downstream calls can inherit the remaining budget
+func (client *RpcClient) CallCmdContext(ctx context.Context, cmd uint32, req interface{}, rsp interface{}) error {
+	data, err := client.codec.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+	body := append(encodeRpcHeader(0, deadline), data...)
+	rspdata, err := client.callCmdContext(ctx, cmd, body)
+	if err != nil {
+		return err
+	}
+	if rsp != nil {
+		err = client.codec.Unmarshal(rspdata, rsp)
+	}
+	return err
+}
+
+// rpc call honoring ctx.Done() in place of a fixed timeout, propagating
+// ctx.Deadline() to the server the same way CallCmdContext does
+func (client *RpcClient) CallContext(ctx context.Context, method string, req interface{}, rsp interface{}) error {
+	data, err := client.codec.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+	body := append(encodeRpcHeader(0, deadline), data...)
+	body = append(body, make([]byte, len(method)+1)...)
+	copy(body[len(body)-len(method)-1:], method)
+	body[len(body)-1] = byte(len(method))
+
+	rspdata, err := client.callCmdContext(ctx, CmdRpcMethod, body)
+	if err != nil {
+		return err
+	}
+	if rsp != nil {
+		err = client.codec.Unmarshal(rspdata, rsp)
+	}
+	return err
+}
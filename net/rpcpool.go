@@ -0,0 +1,330 @@
+package net
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/util"
+)
+
+// load balance strategy for RpcClientPool
+type LbStrategy int
+
+const (
+	LbRoundRobin LbStrategy = iota
+	LbRandom
+	LbLeastPending
+)
+
+var (
+	ErrRpcPoolClosed   = errors.New("rpc pool closed")
+	ErrRpcPoolNoClient = errors.New("rpc pool has no available client")
+)
+
+// one pooled connection, tracks in-flight session count for least-pending balancing
+type poolConn struct {
+	addr    string
+	client  *RpcClient
+	pending int64
+}
+
+// rpc client pool
+//
+// holds N underlying *RpcClient connections, each running its own
+// sessionMap unchanged, and dispatches Call/CallCmd/CallCmdWithTimeout
+// across them using a pluggable load balance strategy. addrs may hold
+// more than one backend address, in which case connections are dialed
+// round-robin across addrs
+type RpcClientPool struct {
+	sync.Mutex
+	addrs        []string
+	engine       *TcpEngin
+	codec        ICodec
+	onConnected  func(*TcpClient)
+	strategy     LbStrategy
+	conns        []*poolConn
+	rrSeq        uint64
+	closed       bool
+	registryStop chan struct{}
+}
+
+// rpc client pool factory, dials size connections spread across addrs
+func NewRpcClientPool(addrs []string, size int, engine *TcpEngin, codec ICodec, strategy LbStrategy, onConnected func(*TcpClient)) (*RpcClientPool, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("rpc pool: no addr")
+	}
+	if size <= 0 {
+		size = 1
+	}
+
+	pool := &RpcClientPool{
+		addrs:       addrs,
+		engine:      engine,
+		codec:       codec,
+		onConnected: onConnected,
+		strategy:    strategy,
+	}
+
+	for i := 0; i < size; i++ {
+		pc, err := pool.dial(addrs[i%len(addrs)])
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		pool.conns = append(pool.conns, pc)
+	}
+
+	return pool, nil
+}
+
+// dial one pooled connection, wiring its close callback to a lazy background redial
+func (pool *RpcClientPool) dial(addr string) (*poolConn, error) {
+	client, err := NewRpcClient(addr, pool.engine, pool.codec, pool.onConnected)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &poolConn{addr: addr, client: client}
+	client.OnClose("pool", func(*TcpClient) {
+		util.Go(func() {
+			pool.redial(pc)
+		})
+	})
+
+	return pc, nil
+}
+
+// redial keeps retrying with backoff until a broken connection is restored
+// or the pool is closed
+func (pool *RpcClientPool) redial(pc *poolConn) {
+	backoff := time.Second
+	for {
+		pool.Lock()
+		closed := pool.closed
+		pool.Unlock()
+		if closed {
+			return
+		}
+
+		client, err := NewRpcClient(pc.addr, pool.engine, pool.codec, pool.onConnected)
+		if err != nil {
+			log.Debug("rpc pool: redial %v failed: %v", pc.addr, err)
+			time.Sleep(backoff)
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+
+		client.OnClose("pool", func(*TcpClient) {
+			util.Go(func() {
+				pool.redial(pc)
+			})
+		})
+
+		pool.Lock()
+		pc.client = client
+		atomic.StoreInt64(&pc.pending, 0)
+		pool.Unlock()
+		return
+	}
+}
+
+// pick selects a pooled connection according to the configured strategy,
+// returning it alongside the *RpcClient it held at that instant. redial
+// swaps pc.client under pool.Lock() whenever a connection drops and comes
+// back, so callers must use the client returned here rather than
+// dereferencing pc.client themselves once the lock is released
+func (pool *RpcClientPool) pick() (*poolConn, *RpcClient, error) {
+	pool.Lock()
+	defer pool.Unlock()
+
+	if pool.closed {
+		return nil, nil, ErrRpcPoolClosed
+	}
+	if len(pool.conns) == 0 {
+		return nil, nil, ErrRpcPoolNoClient
+	}
+
+	var pc *poolConn
+	switch pool.strategy {
+	case LbRandom:
+		pc = pool.conns[rand.Intn(len(pool.conns))]
+	case LbLeastPending:
+		best := pool.conns[0]
+		for _, cand := range pool.conns[1:] {
+			if atomic.LoadInt64(&cand.pending) < atomic.LoadInt64(&best.pending) {
+				best = cand
+			}
+		}
+		pc = best
+	default:
+		idx := atomic.AddUint64(&pool.rrSeq, 1)
+		pc = pool.conns[idx%uint64(len(pool.conns))]
+	}
+	return pc, pc.client, nil
+}
+
+// call cmd against a pooled connection
+func (pool *RpcClientPool) CallCmd(cmd uint32, req interface{}, rsp interface{}) error {
+	pc, client, err := pool.pick()
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&pc.pending, 1)
+	defer atomic.AddInt64(&pc.pending, -1)
+	return client.CallCmd(cmd, req, rsp)
+}
+
+// call cmd with timeout against a pooled connection
+func (pool *RpcClientPool) CallCmdWithTimeout(cmd uint32, req interface{}, rsp interface{}, timeout time.Duration) error {
+	pc, client, err := pool.pick()
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&pc.pending, 1)
+	defer atomic.AddInt64(&pc.pending, -1)
+	return client.CallCmdWithTimeout(cmd, req, rsp, timeout)
+}
+
+// rpc call against a pooled connection
+func (pool *RpcClientPool) Call(method string, req interface{}, rsp interface{}, timeout time.Duration) error {
+	pc, client, err := pool.pick()
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&pc.pending, 1)
+	defer atomic.AddInt64(&pc.pending, -1)
+	return client.Call(method, req, rsp, timeout)
+}
+
+// drainTimeout bounds how long Close waits for a connection's pending
+// calls to finish before closing it out from under them
+const drainTimeout = 5 * time.Second
+
+// close gracefully drains outstanding sessions on every pooled connection:
+// pool.closed is set first so pick() admits no new calls, then each
+// connection's pending counter is polled until it reaches zero or
+// drainTimeout elapses before the underlying client is closed
+func (pool *RpcClientPool) Close() error {
+	pool.Lock()
+	if pool.closed {
+		pool.Unlock()
+		return nil
+	}
+	pool.closed = true
+	conns := pool.conns
+	pool.conns = nil
+	registryStop := pool.registryStop
+	pool.Unlock()
+
+	if registryStop != nil {
+		close(registryStop)
+	}
+
+	var err error
+	for _, pc := range conns {
+		if pc.client == nil {
+			continue
+		}
+		deadline := time.Now().Add(drainTimeout)
+		for atomic.LoadInt64(&pc.pending) > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if e := pc.client.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// hasConn reports whether the pool already holds a connection to addr.
+// NewRpcClientFromRegistry dials every address Resolve returns before its
+// Watch loop starts feeding addConn, and a registry's first watch poll can
+// legitimately re-announce those same addresses as Adds (ZookeeperRegistry
+// does, since it diffs against an empty initial child set) -- addConn must
+// skip addresses already present or the pool ends up with duplicate
+// connections to the same endpoint
+func (pool *RpcClientPool) hasConn(addr string) bool {
+	pool.Lock()
+	defer pool.Unlock()
+	for _, pc := range pool.conns {
+		if pc.addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// addConn dials addr and adds it to the pool, used when a registry watch
+// reports a newly available endpoint
+func (pool *RpcClientPool) addConn(addr string) {
+	if pool.hasConn(addr) {
+		return
+	}
+
+	pc, err := pool.dial(addr)
+	if err != nil {
+		log.Debug("rpc pool: dial new endpoint %v failed: %v", addr, err)
+		return
+	}
+
+	pool.Lock()
+	if pool.closed {
+		pool.Unlock()
+		pc.client.Close()
+		return
+	}
+	for _, existing := range pool.conns {
+		if existing.addr == addr {
+			pool.Unlock()
+			pc.client.Close()
+			return
+		}
+	}
+	pool.conns = append(pool.conns, pc)
+	pool.Unlock()
+}
+
+// removeConn drains and drops every pooled connection dialed to addr, used
+// when a registry watch reports an endpoint going away
+func (pool *RpcClientPool) removeConn(addr string) {
+	pool.Lock()
+	kept := pool.conns[:0]
+	var removed []*poolConn
+	for _, pc := range pool.conns {
+		if pc.addr == addr {
+			removed = append(removed, pc)
+		} else {
+			kept = append(kept, pc)
+		}
+	}
+	pool.conns = kept
+	pool.Unlock()
+
+	for _, pc := range removed {
+		if pc.client != nil {
+			deadline := time.Now().Add(drainTimeout)
+			for atomic.LoadInt64(&pc.pending) > 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			pc.client.Close()
+		}
+	}
+}
+
+// call with an explicit per-call codec tag against a pooled connection,
+// see RpcClient.CallWithCodec
+func (pool *RpcClientPool) CallWithCodec(tag CodecType, method string, req interface{}, rsp interface{}, timeout time.Duration) error {
+	pc, client, err := pool.pick()
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&pc.pending, 1)
+	defer atomic.AddInt64(&pc.pending, -1)
+	return client.CallWithCodec(tag, method, req, rsp, timeout)
+}
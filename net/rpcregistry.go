@@ -0,0 +1,69 @@
+package net
+
+import (
+	"time"
+
+	"github.com/nothollyhigh/kiss/util"
+)
+
+// rpc client pool factory backed by a Registry: resolves the live endpoint
+// set for serviceName, dials a connection to each, and reacts to the
+// registry's watch stream by opening or draining connections at runtime
+func NewRpcClientFromRegistry(registry Registry, serviceName string, engine *TcpEngin, codec ICodec, strategy LbStrategy, onConnected func(*TcpClient)) (*RpcClientPool, error) {
+	attrs, err := registry.Resolve(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		addrs = append(addrs, attr.Addr)
+	}
+	if len(addrs) == 0 {
+		return nil, ErrRpcPoolNoClient
+	}
+
+	pool, err := NewRpcClientPool(addrs, len(addrs), engine, codec, strategy, onConnected)
+	if err != nil {
+		return nil, err
+	}
+
+	stopped := make(chan struct{})
+	events, err := registry.Watch(serviceName, stopped)
+	if err != nil {
+		close(stopped)
+		pool.Close()
+		return nil, err
+	}
+
+	pool.Lock()
+	pool.registryStop = stopped
+	pool.Unlock()
+
+	util.Go(func() {
+		for ev := range events {
+			if ev.Add {
+				pool.addConn(ev.Service.Addr)
+			} else {
+				pool.removeConn(ev.Service.Addr)
+			}
+		}
+	})
+
+	return pool, nil
+}
+
+// RegisterService publishes this server's attr under registry, refreshing
+// its TTL lease for as long as the server keeps running, and deregisters it
+// once the server closes
+func (server *TcpServer) RegisterService(registry Registry, attr ServiceAttr, ttl time.Duration) error {
+	if err := registry.Register(attr, ttl); err != nil {
+		return err
+	}
+
+	server.OnClose("registry", func() {
+		registry.Deregister(attr)
+	})
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package net
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CallStrategy controls how Call/CallWithStrategy behaves when a call fails
+type CallStrategy int
+
+const (
+	// StrategyFailfast returns the first error immediately, the existing
+	// Call/CallCmd behavior
+	StrategyFailfast CallStrategy = iota
+	// StrategyFailover retries the call on another connection (a pool's
+	// next connection, or the same connection for a plain RpcClient) up to
+	// RetryPolicy.MaxAttempts, returning the last error if every attempt fails
+	StrategyFailover
+	// StrategyFailsafe behaves like StrategyFailover but swallows the
+	// final error instead of returning it, for calls whose failure the
+	// caller doesn't want to handle
+	StrategyFailsafe
+)
+
+// RetryPolicy controls StrategyFailover/StrategyFailsafe retries
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+// DefaultRetryPolicy.MaxAttempts is used by CallWithStrategy whenever
+// policy.MaxAttempts <= 0; the backoff between attempts always comes from
+// the policy passed in, so DefaultRetryPolicy.Backoff is not applied there
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, Backoff: 100 * time.Millisecond}
+
+// RetryableError reports whether err is safe to retry. Transport-level
+// failures are retryable; codec/unmarshal errors are not, since retrying
+// them just fails the same way on every attempt
+func RetryableError(err error) bool {
+	switch err {
+	case ErrRpcClientIsDisconnected, ErrRpcCallTimeout, ErrRpcCallClientError:
+		return true
+	default:
+		return false
+	}
+}
+
+// call with an explicit strategy; a plain RpcClient has a single connection,
+// so StrategyFailover/StrategyFailsafe simply retry on it up to
+// RetryPolicy.MaxAttempts (callCmdWithTimeout allocates a fresh seq per
+// attempt, keeping sessionMap consistent)
+func (client *RpcClient) CallWithStrategy(method string, req interface{}, rsp interface{}, timeout time.Duration, strategy CallStrategy, policy RetryPolicy) error {
+	if strategy == StrategyFailfast {
+		return client.Call(method, req, rsp, timeout)
+	}
+
+	attempts := policy.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultRetryPolicy.MaxAttempts
+	}
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		err = client.Call(method, req, rsp, timeout)
+		if err == nil || !RetryableError(err) {
+			break
+		}
+		if policy.Backoff > 0 {
+			time.Sleep(policy.Backoff)
+		}
+	}
+
+	if err != nil && strategy == StrategyFailsafe {
+		return nil
+	}
+	return err
+}
+
+// pickUntried selects a pooled connection not yet present in tried, falling
+// back to the normal pick() strategy once every connection has been
+// attempted. Like pick(), it returns the *RpcClient it held at selection
+// time so callers never dereference pc.client outside pool.Lock(), where
+// redial can be swapping it concurrently
+func (pool *RpcClientPool) pickUntried(tried map[*poolConn]bool) (*poolConn, *RpcClient, error) {
+	pool.Lock()
+	defer pool.Unlock()
+
+	if pool.closed {
+		return nil, nil, ErrRpcPoolClosed
+	}
+	if len(pool.conns) == 0 {
+		return nil, nil, ErrRpcPoolNoClient
+	}
+
+	for _, pc := range pool.conns {
+		if !tried[pc] {
+			return pc, pc.client, nil
+		}
+	}
+
+	idx := atomic.AddUint64(&pool.rrSeq, 1)
+	pc := pool.conns[idx%uint64(len(pool.conns))]
+	return pc, pc.client, nil
+}
+
+// call with an explicit strategy across the pool: StrategyFailover retries
+// on the pool's next connection up to RetryPolicy.MaxAttempts, stopping at
+// the first non-retryable error, and StrategyFailsafe additionally swallows
+// the final error
+func (pool *RpcClientPool) CallWithStrategy(method string, req interface{}, rsp interface{}, timeout time.Duration, strategy CallStrategy, policy RetryPolicy) error {
+	if strategy == StrategyFailfast {
+		return pool.Call(method, req, rsp, timeout)
+	}
+
+	attempts := policy.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultRetryPolicy.MaxAttempts
+	}
+
+	tried := map[*poolConn]bool{}
+	var err error
+	for i := 0; i < attempts; i++ {
+		var pc *poolConn
+		var client *RpcClient
+		pc, client, err = pool.pickUntried(tried)
+		if err != nil {
+			break
+		}
+		tried[pc] = true
+
+		atomic.AddInt64(&pc.pending, 1)
+		err = client.Call(method, req, rsp, timeout)
+		atomic.AddInt64(&pc.pending, -1)
+		if err == nil || !RetryableError(err) {
+			break
+		}
+
+		if policy.Backoff > 0 {
+			time.Sleep(policy.Backoff)
+		}
+	}
+
+	if err != nil && strategy == StrategyFailsafe {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,26 @@
+package net
+
+import "testing"
+
+func TestRetryableError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"disconnected", ErrRpcClientIsDisconnected, true},
+		{"timeout", ErrRpcCallTimeout, true},
+		{"client error", ErrRpcCallClientError, true},
+		{"codec error", ErrCodecNotRegistered, false},
+		{"pool closed", ErrRpcPoolClosed, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := RetryableError(c.err); got != c.want {
+				t.Errorf("RetryableError(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
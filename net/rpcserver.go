@@ -0,0 +1,73 @@
+package net
+
+import (
+	"time"
+
+	"github.com/nothollyhigh/kiss/log"
+	"github.com/nothollyhigh/kiss/util"
+)
+
+// ServeRpc installs the server-side message dispatcher on engine.
+// CmdRpcMethod requests (Call/CallWithCodec/CallContext) always carry the
+// fixed header decodeRpcHeader expects; ServeRpc reads it there to select
+// the codec a tag names and to deadline-bind the handler's context, then
+// resolves an incoming CmdRpcCancel against that context. Any other cmd
+// registered via engine.handlers is dispatched without touching its body --
+// CallCmd/CallCmdWithTimeout own their whole body and never prepend a
+// header, so running decodeRpcHeader against it would misparse the
+// caller's payload as one. CallCmdContext does prepend the header to its
+// cmd, but ServeRpc does not read it there either: such a handler's context
+// carries no deadline, and the handler reads the header itself with
+// DecodeDeadline. This is the counterpart to the dispatcher NewRpcClient
+// installs on the client side
+func ServeRpc(engine *TcpEngin) {
+	engine.HandleOnMessage(func(c *TcpClient, msg IMessage) {
+		switch msg.Cmd() {
+		case CmdPing:
+		case CmdRpcCancel:
+			cancelHandlerContext(c, msg.RpcSeq())
+		case CmdRpcMethod:
+			handler, ok := engine.handlers[msg.Cmd()]
+			if !ok {
+				log.Debug("no handler for cmd %v, ip: %v", msg.Cmd(), c.Ip())
+				return
+			}
+
+			tag, deadline, _ := decodeRpcHeader(msg.Body())
+			var codecDone func()
+			if tag != 0 {
+				codec, ok := DefaultCodecRegistry.Get(tag)
+				if !ok {
+					log.Debug("no codec registered for tag %v, ip: %v", tag, c.Ip())
+					return
+				}
+				codecDone = registerHandlerCodec(c, msg.RpcSeq(), codec)
+			}
+
+			engine.Add(1)
+			go func() {
+				defer engine.Done()
+				defer util.HandlePanic()
+				if codecDone != nil {
+					defer codecDone()
+				}
+				_, done := registerHandlerContext(c, msg.RpcSeq(), deadline)
+				defer done()
+				handler(c, msg)
+			}()
+		default:
+			if handler, ok := engine.handlers[msg.Cmd()]; ok {
+				engine.Add(1)
+				go func() {
+					defer engine.Done()
+					defer util.HandlePanic()
+					_, done := registerHandlerContext(c, msg.RpcSeq(), time.Time{})
+					defer done()
+					handler(c, msg)
+				}()
+			} else {
+				log.Debug("no handler for cmd %v", msg.Cmd())
+			}
+		}
+	})
+}